@@ -26,6 +26,12 @@ const (
 	defragNormalInterval = 1 * time.Hour
 	defragFailedInterval = 1 * time.Minute
 
+	hashCheckInterval     = 1 * time.Hour
+	hashCheckRetries      = 7
+	hashCheckRetryBackoff = 500 * time.Millisecond
+
+	leaseReinitRetryInterval = 1 * time.Second
+
 	// waitServerTimeout is the timeout for waiting server to start.
 	waitServerTimeout = 10 * time.Second
 
@@ -35,8 +41,10 @@ const (
 	dialKeepAliveTime    = 1 * time.Minute
 	dialKeepAliveTimeout = 1 * time.Minute
 
-	// lease config
-	leaseTTL = clientv3.MaxLeaseTTL // 9000000000Second=285Year
+	// ApplyTimeout is the max time to wait for the local applied index
+	// to catch up with the committed index before a linearizable renew
+	// gives up.
+	ApplyTimeout = 5 * time.Second
 )
 
 type (
@@ -51,6 +59,10 @@ type (
 
 		// Etcd is non-nil only it is a writer.
 		Etcd *EtcdStatus `yaml:"etcd,omitempty"`
+
+		// HashKV is non-nil only it is a writer and
+		// the hash checker has run at least once.
+		HashKV *HashKVStatus `yaml:"hashKV,omitempty"`
 	}
 
 	// EtcdStatus is the etcd status,
@@ -98,6 +110,7 @@ func (s *etcdStats) toEtcdStatus() *EtcdStatus {
 type cluster struct {
 	opt            *option.Options
 	requestTimeout time.Duration
+	leaseTTL       time.Duration
 
 	layout *Layout
 
@@ -112,6 +125,28 @@ type cluster struct {
 	leaseMutex   sync.RWMutex
 	sessionMutex sync.RWMutex
 
+	corrupted      bool
+	corruptedMutex sync.RWMutex
+
+	hashKV      *HashKVStatus
+	hashKVMutex sync.RWMutex
+
+	watcher      clientv3.Watcher
+	watcherMutex sync.RWMutex
+
+	watchCancels []context.CancelFunc
+	watchMutex   sync.Mutex
+
+	// leaseLost is false until the KeepAlive stream is observed to be
+	// lost; it starts false so the very first PutUnderLease (before
+	// keepAliveLoop has even started) isn't rejected as "lost". It's
+	// also cleared by initLease as soon as a lease is confirmed alive,
+	// for the same reason on every re-registration after a loss, not
+	// just on cold start.
+	leaseLost         bool
+	lastKeepAliveTime time.Time
+	leaseAliveMutex   sync.RWMutex
+
 	done chan struct{}
 }
 
@@ -124,6 +159,11 @@ func New(opt *option.Options) (Cluster, error) {
 		return nil, fmt.Errorf("invalid cluster request timeout: %v", err)
 	}
 
+	leaseTTL, err := time.ParseDuration(opt.ClusterLeaseTTL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cluster lease ttl: %v", err)
+	}
+
 	members, err := newMembers(opt)
 	if err != nil {
 		return nil, fmt.Errorf("new members failed: %v", err)
@@ -132,6 +172,7 @@ func New(opt *option.Options) (Cluster, error) {
 	c := &cluster{
 		opt:            opt,
 		requestTimeout: requestTimeout,
+		leaseTTL:       leaseTTL,
 		members:        members,
 		done:           make(chan struct{}),
 	}
@@ -189,6 +230,7 @@ func (c *cluster) run() {
 	// FIXME: @miaojun Please care this routine in graceful update.
 	if c.opt.ClusterRole == "writer" {
 		go c.defrag()
+		go c.checkHashKV()
 	}
 
 	c.heartbeat()
@@ -351,6 +393,88 @@ func (c *cluster) closeClient() {
 	c.client = nil
 }
 
+// ErrTimeoutWaitAppliedIndex is returned by waitApplyPendingCommits when
+// the local applied index doesn't catch up with the committed index
+// within ApplyTimeout.
+type ErrTimeoutWaitAppliedIndex struct {
+	waited time.Duration
+}
+
+func (e *ErrTimeoutWaitAppliedIndex) Error() string {
+	return fmt.Sprintf("timeout waiting applied index to catch up after %v", e.waited)
+}
+
+// waitApplyPendingCommits blocks until whatever member ends up serving
+// the next request has applied every entry committed so far, so a
+// subsequent read or lease op is linearizable with whatever the leader
+// just wrote. Writers check their own embedded server's applied index;
+// readers have no embedded server to check, so they fall back to
+// waitApplyPendingCommitsRemote instead.
+func (c *cluster) waitApplyPendingCommits(ctx context.Context) error {
+	server, err := c.getServer()
+	if err != nil {
+		return c.waitApplyPendingCommitsRemote(ctx)
+	}
+
+	if server.Server.AppliedIndex() >= server.Server.CommittedIndex() {
+		return nil
+	}
+
+	select {
+	case <-server.Server.ApplyWait():
+		return nil
+	case <-time.After(ApplyTimeout):
+		return &ErrTimeoutWaitAppliedIndex{waited: ApplyTimeout}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// waitApplyPendingCommitsRemote is the reader-side fallback: a
+// linearizable Get doesn't return until the member serving it has
+// caught up to the leader's commit index as of the time the request was
+// received, which the Lease service's own TimeToLive RPC doesn't
+// guarantee on its own. An arbitrary existing key works as the barrier;
+// the lease key itself is as good as any.
+func (c *cluster) waitApplyPendingCommitsRemote(ctx context.Context) error {
+	client, err := c.getClient()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Get(ctx, c.Layout().Lease())
+	return err
+}
+
+// RenewLease linearizably renews the cluster's own lease. It waits for
+// pending commits to apply first (locally on a writer, via a barrier
+// read on a reader), so the renewed TTL reflects the latest committed
+// state instead of a record this member hasn't caught up on yet.
+func (c *cluster) RenewLease() error {
+	ctx := c.requestContext()
+
+	if err := c.waitApplyPendingCommits(ctx); err != nil {
+		return err
+	}
+
+	client, err := c.getClient()
+	if err != nil {
+		return err
+	}
+
+	lease, err := c.getLease()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Lease.KeepAliveOnce(ctx, lease)
+	if err != nil {
+		return fmt.Errorf("renew lease failed: %v", err)
+	}
+
+	return nil
+}
+
 func (c *cluster) getLease() (clientv3.LeaseID, error) {
 	c.leaseMutex.RLock()
 	defer c.leaseMutex.RUnlock()
@@ -378,11 +502,46 @@ func (c *cluster) initLease() error {
 			return err
 		}
 
+		client, err := c.getClient()
+		if err != nil {
+			return err
+		}
+
+		ttlResp, err := client.Lease.TimeToLive(c.requestContext(), lease)
+		if err != nil {
+			return fmt.Errorf("get ttl of lease %s failed: %v", *leaseStr, err)
+		}
+		if ttlResp.TTL == 0 {
+			// The lease was just granted elsewhere and this member
+			// hasn't caught up yet: a raw TTL=0 here would otherwise
+			// look like an expired lease and take down the session
+			// it's about to back. Wait for this member to catch up
+			// and re-check once before trusting it.
+			if err := c.waitApplyPendingCommits(c.requestContext()); err != nil {
+				return fmt.Errorf("wait apply pending commits failed: %v", err)
+			}
+			ttlResp, err = client.Lease.TimeToLive(c.requestContext(), lease)
+			if err != nil {
+				return fmt.Errorf("get ttl of lease %s failed: %v", *leaseStr, err)
+			}
+			if ttlResp.TTL == 0 {
+				return fmt.Errorf("lease %s expired", *leaseStr)
+			}
+		}
+
 		c.leaseMutex.Lock()
 		c.lease = &lease
 		logger.Infof("lease is ready")
 		c.leaseMutex.Unlock()
 
+		// The TTL check above just confirmed this lease is alive, so
+		// clear leaseLost now rather than waiting for keepAliveLoop's
+		// first KeepAlive response: callers re-attaching after a loss
+		// (onLeaseLost) may PutUnderLease before that loop ever runs.
+		c.setLeaseLost(false)
+
+		go c.keepAliveLoop()
+
 		return nil
 	}
 
@@ -391,7 +550,7 @@ func (c *cluster) initLease() error {
 		return err
 	}
 
-	respGrant, err := client.Lease.Grant(c.requestContext(), leaseTTL)
+	respGrant, err := client.Lease.Grant(c.requestContext(), int64(c.leaseTTL.Seconds()))
 	if err != nil {
 		return err
 	}
@@ -403,12 +562,21 @@ func (c *cluster) initLease() error {
 	logger.Infof("lease is ready")
 	c.leaseMutex.Unlock()
 
+	// The Grant above just confirmed this lease is alive, so clear
+	// leaseLost before the self-registration write: keepAliveLoop
+	// hasn't started yet, and PutUnderLease would otherwise reject
+	// this very call as writing under a lost lease (e.g. after
+	// onLeaseLost sets leaseLost and re-enters initLease).
+	c.setLeaseLost(false)
+
 	err = c.PutUnderLease(c.Layout().Lease(), fmt.Sprintf("%x", lease))
 	if err != nil {
 		return fmt.Errorf("put lease to %s failed: %v",
 			c.Layout().Lease(), err)
 	}
 
+	go c.keepAliveLoop()
+
 	return nil
 }
 
@@ -445,6 +613,7 @@ func (c *cluster) getSession() (*concurrency.Session, error) {
 		return nil, fmt.Errorf("create session failed: %v", err)
 	}
 
+	c.session = session
 	logger.Infof("session is ready")
 
 	return session, nil
@@ -581,15 +750,60 @@ func (c *cluster) heartbeat() {
 	}
 }
 
+// defrag runs under the defrag election, so exactly one writer defrags
+// at a time even in a multi-writer cluster. It keeps re-campaigning
+// until the cluster is closed, rather than giving up for good on the
+// first lost or failed campaign.
 func (c *cluster) defrag() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-c.done
+		cancel()
+	}()
+
+	for {
+		handle, err := c.Campaign(ctx, defragElectionPurpose, c.members.self().Name)
+		if err != nil {
+			select {
+			case <-c.done:
+				return
+			default:
+			}
+
+			logger.Errorf("campaign for defrag leadership failed: %v, retrying in %v", err, defragFailedInterval)
+			select {
+			case <-time.After(defragFailedInterval):
+				continue
+			case <-c.done:
+				return
+			}
+		}
+
+		if closed := c.runDefrag(handle); closed {
+			return
+		}
+	}
+}
+
+// runDefrag defrags on defragInterval while holding the given election
+// leadership, returning true once the cluster itself is shutting down
+// (false means leadership was lost, so defrag() should re-campaign).
+func (c *cluster) runDefrag(handle LeadershipHandle) bool {
+	defer handle.Resign(context.Background())
+
 	defragInterval := defragNormalInterval
 	for {
 		select {
+		case <-handle.Done():
+			logger.Errorf("lost defrag leadership, re-campaigning")
+			return false
 		case <-time.After(defragInterval):
 			client, err := c.getClient()
 			if err != nil {
 				defragInterval = defragFailedInterval
 				logger.Errorf("defrag failed: get client failed: %v", err)
+				continue
 			}
 
 			// NOTICE: It need longer time than normal ones.
@@ -603,7 +817,7 @@ func (c *cluster) defrag() {
 			logger.Infof("defrag successfully")
 			defragInterval = defragNormalInterval
 		case <-c.done:
-			return
+			return true
 		}
 	}
 }
@@ -625,6 +839,7 @@ func (c *cluster) syncStatus() error {
 			return err
 		}
 		status.Etcd = stats.toEtcdStatus()
+		status.HashKV = c.lastHashKV()
 	}
 
 	status.LastHeartbeatTime = time.Now().Format(time.RFC3339)
@@ -708,6 +923,7 @@ func (c *cluster) Close(wg *sync.WaitGroup) {
 
 	close(c.done)
 
+	c.closeWatches()
 	c.closeSession()
 	c.closeClient()
 	c.closeServer()
@@ -0,0 +1,105 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+
+	"go.etcd.io/etcd/clientv3/concurrency"
+)
+
+// defragElectionPurpose is the election purpose defrag() campaigns
+// under, so exactly one writer defrags at a time.
+const defragElectionPurpose = "defrag"
+
+type (
+	// Leader is the current winner of an election, observed via
+	// Observe.
+	Leader struct {
+		Key   string
+		Value string
+	}
+
+	// LeadershipHandle is held by the winner of a Campaign. It lets the
+	// holder give up leadership voluntarily via Resign, or find out
+	// when it's lost leadership involuntarily (lease revoked, network
+	// partition) via Done.
+	LeadershipHandle interface {
+		// Resign gives up leadership, letting another candidate win.
+		Resign(ctx context.Context) error
+
+		// Done fires once the underlying session is lost.
+		Done() <-chan struct{}
+	}
+
+	leadershipHandle struct {
+		election *concurrency.Election
+		session  *concurrency.Session
+	}
+)
+
+func (h *leadershipHandle) Resign(ctx context.Context) error {
+	return h.election.Resign(ctx)
+}
+
+func (h *leadershipHandle) Done() <-chan struct{} {
+	return h.session.Done()
+}
+
+// Campaign blocks until this member wins the named election.
+func (c *cluster) Campaign(ctx context.Context, purpose string, value string) (LeadershipHandle, error) {
+	session, err := c.getSession()
+	if err != nil {
+		return nil, err
+	}
+
+	election := concurrency.NewElection(session, c.Layout().Election(purpose))
+	if err := election.Campaign(ctx, value); err != nil {
+		return nil, fmt.Errorf("campaign for %s failed: %v", purpose, err)
+	}
+
+	return &leadershipHandle{election: election, session: session}, nil
+}
+
+// Observe streams the current leader of the named election as it
+// changes.
+func (c *cluster) Observe(ctx context.Context, purpose string) (<-chan Leader, error) {
+	session, err := c.getSession()
+	if err != nil {
+		return nil, err
+	}
+
+	election := concurrency.NewElection(session, c.Layout().Election(purpose))
+	observeChan := election.Observe(ctx)
+
+	out := make(chan Leader)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case resp, ok := <-observeChan:
+				if !ok {
+					return
+				}
+				if len(resp.Kvs) == 0 {
+					continue
+				}
+
+				leader := Leader{
+					Key:   string(resp.Kvs[0].Key),
+					Value: string(resp.Kvs[0].Value),
+				}
+				select {
+				case out <- leader:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			case <-c.done:
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
@@ -0,0 +1,257 @@
+package cluster
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/megaease/easegateway/pkg/logger"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+type (
+	// ConsistencyReport is the result of the latest cross-member
+	// hash consistency check.
+	ConsistencyReport struct {
+		// Corrupted is true if any pair of members disagreed on the
+		// hash of the same revision.
+		Corrupted bool `yaml:"corrupted"`
+
+		// Revision is the leader revision hashes were compared at.
+		Revision int64 `yaml:"revision"`
+
+		// Members is the per-member hash/revision observed in the
+		// latest round, keyed by member name.
+		Members map[string]*HashKVStatus `yaml:"members"`
+	}
+
+	// HashKVStatus is a member's MVCC hash at a given revision,
+	// extracted from clientv3.HashKVResponse.
+	HashKVStatus struct {
+		Revision int64  `yaml:"revision"`
+		Hash     uint32 `yaml:"hash"`
+	}
+)
+
+// ConsistencyReport returns the result of an on-demand cross-member hash
+// consistency check, for callers that don't want to wait for the next
+// scheduled run of checkHashKV.
+func (c *cluster) ConsistencyReport() (*ConsistencyReport, error) {
+	client, err := c.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.checkHashKVOnce(client)
+}
+
+// checkHashKV periodically verifies that every member's MVCC hash at the
+// same revision agrees, the same safety net etcd's functional tester
+// provides against silent storage corruption. It runs only on writers,
+// alongside defrag(), and gates advanceCompactRevision on the result.
+func (c *cluster) checkHashKV() {
+	for {
+		select {
+		case <-time.After(hashCheckInterval):
+			client, err := c.getClient()
+			if err != nil {
+				logger.Errorf("hash check failed: get client failed: %v", err)
+				continue
+			}
+
+			report, err := c.checkHashKVOnce(client)
+			if err != nil {
+				logger.Errorf("hash check failed: %v", err)
+				continue
+			}
+
+			if report.Corrupted {
+				logger.Errorf("hash check: members disagree on hash at the same revision: %+v", report.Members)
+				c.setCorrupted(true)
+				if err := c.raiseCorruptionAlarm(); err != nil {
+					logger.Errorf("raise corruption alarm failed: %v", err)
+				}
+				continue
+			}
+
+			c.setCorrupted(false)
+
+			if err := c.advanceCompactRevision(client, report.Revision); err != nil {
+				logger.Errorf("advance compact revision failed: %v", err)
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// checkHashKVOnce lists the current members and compares their MVCC hash
+// at the leader's compact revision, skipping members whose revision lags
+// more than opt.ClusterHashCheckRevisionLag behind.
+func (c *cluster) checkHashKVOnce(client *clientv3.Client) (*ConsistencyReport, error) {
+	respList, err := client.MemberList(c.requestContext())
+	if err != nil {
+		return nil, fmt.Errorf("list members failed: %v", err)
+	}
+
+	leaderRev, err := c.compactRevision(client)
+	if err != nil {
+		return nil, fmt.Errorf("get compact revision failed: %v", err)
+	}
+
+	report := &ConsistencyReport{
+		Revision: leaderRev,
+		Members:  make(map[string]*HashKVStatus),
+	}
+
+	for _, member := range respList.Members {
+		for _, endpoint := range member.ClientURLs {
+			statusResp, err := client.Status(c.requestContext(), endpoint)
+			if err != nil {
+				logger.Errorf("status of %s(%s) failed: %v", member.Name, endpoint, err)
+				break
+			}
+
+			if leaderRev-statusResp.Header.Revision > c.opt.ClusterHashCheckRevisionLag {
+				logger.Infof("skip %s(%s): revision %d lags leader's %d too much",
+					member.Name, endpoint, statusResp.Header.Revision, leaderRev)
+				break
+			}
+
+			status, err := c.hashKVWithRetry(endpoint, leaderRev)
+			if err != nil {
+				logger.Errorf("hash kv of %s(%s) failed: %v", member.Name, endpoint, err)
+				break
+			}
+
+			report.Members[member.Name] = status
+			if member.Name == c.members.self().Name {
+				c.setLastHashKV(status)
+			}
+
+			break
+		}
+	}
+
+	report.Corrupted = hashesDiverge(report.Members)
+
+	return report, nil
+}
+
+// hashesDiverge reports whether any two members disagree on their hash,
+// split out of checkHashKVOnce so it can be unit tested without a live
+// etcd cluster.
+func hashesDiverge(members map[string]*HashKVStatus) bool {
+	var first *HashKVStatus
+	for _, status := range members {
+		if first == nil {
+			first = status
+			continue
+		}
+		if first.Hash != status.Hash {
+			return true
+		}
+	}
+	return false
+}
+
+// hashKVWithRetry calls HashKV with exponential backoff, tolerating the
+// transient RPC failures that are common against a busy member.
+func (c *cluster) hashKVWithRetry(endpoint string, revision int64) (*HashKVStatus, error) {
+	client, err := c.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	backoff := hashCheckRetryBackoff
+	var lastErr error
+	for i := 0; i < hashCheckRetries; i++ {
+		resp, err := client.HashKV(c.requestContext(), endpoint, revision)
+		if err == nil {
+			return &HashKVStatus{Revision: resp.Header.Revision, Hash: resp.Hash}, nil
+		}
+
+		lastErr = err
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	return nil, fmt.Errorf("hash kv of %s failed after %d attempts: %v", endpoint, hashCheckRetries, lastErr)
+}
+
+// compactRevision returns the revision to compare hashes at: the current
+// header revision, as reported by the leader.
+func (c *cluster) compactRevision(client *clientv3.Client) (int64, error) {
+	resp, err := client.Status(c.requestContext(), c.opt.ClusterPeerURL)
+	if err != nil {
+		return 0, err
+	}
+
+	respList, err := client.MemberList(c.requestContext())
+	if err != nil {
+		return 0, err
+	}
+	for _, member := range respList.Members {
+		if member.ID == resp.Leader && len(member.ClientURLs) > 0 {
+			leaderResp, err := client.Status(c.requestContext(), member.ClientURLs[0])
+			if err != nil {
+				return 0, err
+			}
+			return leaderResp.Header.Revision, nil
+		}
+	}
+	return 0, fmt.Errorf("leader %x not found in member list", resp.Leader)
+}
+
+// advanceCompactRevision compacts the store up to rev, but refuses to
+// run while corruption has been flagged so operators get a chance to
+// investigate before old revisions are thrown away for good.
+func (c *cluster) advanceCompactRevision(client *clientv3.Client, rev int64) error {
+	alarm, err := c.Get(c.Layout().CorruptionAlarm())
+	if err != nil {
+		return fmt.Errorf("get corruption alarm failed: %v", err)
+	}
+
+	if c.isCorrupted() || alarm != nil {
+		logger.Errorf("refusing to advance compact revision to %d: corruption flagged, reset the alarm first", rev)
+		return nil
+	}
+
+	if rev <= 0 {
+		return nil
+	}
+
+	_, err = client.Compact(c.requestContext(), rev)
+	return err
+}
+
+func (c *cluster) setLastHashKV(status *HashKVStatus) {
+	c.hashKVMutex.Lock()
+	defer c.hashKVMutex.Unlock()
+	c.hashKV = status
+}
+
+func (c *cluster) lastHashKV() *HashKVStatus {
+	c.hashKVMutex.RLock()
+	defer c.hashKVMutex.RUnlock()
+	return c.hashKV
+}
+
+func (c *cluster) setCorrupted(corrupted bool) {
+	c.corruptedMutex.Lock()
+	defer c.corruptedMutex.Unlock()
+	c.corrupted = corrupted
+}
+
+func (c *cluster) isCorrupted() bool {
+	c.corruptedMutex.RLock()
+	defer c.corruptedMutex.RUnlock()
+	return c.corrupted
+}
+
+// raiseCorruptionAlarm publishes the corruption alarm key so operators
+// (and advanceCompactRevision, which refuses to run while it's set) can
+// see it.
+func (c *cluster) raiseCorruptionAlarm() error {
+	return c.Put(c.Layout().CorruptionAlarm(), time.Now().Format(time.RFC3339))
+}
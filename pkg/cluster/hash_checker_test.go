@@ -0,0 +1,50 @@
+package cluster
+
+import "testing"
+
+func TestHashesDiverge(t *testing.T) {
+	tests := []struct {
+		name    string
+		members map[string]*HashKVStatus
+		want    bool
+	}{
+		{
+			name:    "empty",
+			members: map[string]*HashKVStatus{},
+			want:    false,
+		},
+		{
+			name: "single member",
+			members: map[string]*HashKVStatus{
+				"m1": {Revision: 10, Hash: 123},
+			},
+			want: false,
+		},
+		{
+			name: "all agree",
+			members: map[string]*HashKVStatus{
+				"m1": {Revision: 10, Hash: 123},
+				"m2": {Revision: 10, Hash: 123},
+				"m3": {Revision: 10, Hash: 123},
+			},
+			want: false,
+		},
+		{
+			name: "one diverges",
+			members: map[string]*HashKVStatus{
+				"m1": {Revision: 10, Hash: 123},
+				"m2": {Revision: 10, Hash: 456},
+				"m3": {Revision: 10, Hash: 123},
+			},
+			want: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := hashesDiverge(test.members); got != test.want {
+				t.Errorf("hashesDiverge() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
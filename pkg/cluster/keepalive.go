@@ -0,0 +1,149 @@
+package cluster
+
+import (
+	"context"
+	"time"
+
+	"github.com/megaease/easegateway/pkg/logger"
+)
+
+type (
+	// LeaseStatus reports the health of the cluster's own lease
+	// KeepAlive stream, for observability.
+	LeaseStatus struct {
+		Alive bool `yaml:"alive"`
+
+		// RFC3339 format, empty if never succeeded.
+		LastKeepAliveTime string `yaml:"lastKeepAliveTime,omitempty"`
+	}
+
+	// ErrLeaseLost is returned by PutUnderLease when the lease's
+	// KeepAlive stream has been lost since the last successful
+	// heartbeat, so callers fail fast instead of writing under a lease
+	// that's about to be revoked.
+	ErrLeaseLost struct{}
+)
+
+func (e *ErrLeaseLost) Error() string {
+	return "lease keep alive stream lost"
+}
+
+// keepAliveLoop streams KeepAlive responses for the current lease. If
+// the stream closes, the lease is gone (revoked, TTL starved while this
+// member was partitioned, etc), so it tears down local session state
+// and re-enters initLease from scratch rather than leaving stale status
+// keys behind for PurgeMember to clean up by hand.
+func (c *cluster) keepAliveLoop() {
+	lease, err := c.getLease()
+	if err != nil {
+		logger.Errorf("keep alive failed: get lease failed: %v", err)
+		return
+	}
+
+	client, err := c.getClient()
+	if err != nil {
+		logger.Errorf("keep alive failed: get client failed: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	respChan, err := client.Lease.KeepAlive(ctx, lease)
+	if err != nil {
+		logger.Errorf("keep alive of lease %x failed: %v", lease, err)
+		c.onLeaseLost()
+		return
+	}
+
+	c.setLeaseLost(false)
+
+	for {
+		select {
+		case resp, ok := <-respChan:
+			if !ok || resp == nil {
+				logger.Errorf("keep alive of lease %x lost", lease)
+				c.onLeaseLost()
+				return
+			}
+			c.recordKeepAlive()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// onLeaseLost cleans up after a lost lease and re-registers the member
+// from scratch: revoke what's left of the lease, drop the local
+// session, and grant a fresh one via initLease, retrying until that
+// succeeds or the cluster is closed rather than leaving c.lease nil
+// forever on a single transient failure.
+func (c *cluster) onLeaseLost() {
+	c.setLeaseLost(true)
+
+	if lease, err := c.getLease(); err == nil {
+		if client, err := c.getClient(); err == nil {
+			if _, err := client.Lease.Revoke(c.requestContext(), lease); err != nil {
+				logger.Errorf("revoke lost lease %x failed: %v", lease, err)
+			}
+		}
+	}
+
+	c.closeSession()
+
+	c.leaseMutex.Lock()
+	c.lease = nil
+	c.leaseMutex.Unlock()
+
+	for {
+		if err := c.initLease(); err != nil {
+			logger.Errorf("re-init lease after loss failed: %v, retrying in %v", err, leaseReinitRetryInterval)
+			select {
+			case <-time.After(leaseReinitRetryInterval):
+				continue
+			case <-c.done:
+				return
+			}
+		}
+		return
+	}
+}
+
+func (c *cluster) setLeaseLost(lost bool) {
+	c.leaseAliveMutex.Lock()
+	defer c.leaseAliveMutex.Unlock()
+	c.leaseLost = lost
+}
+
+func (c *cluster) recordKeepAlive() {
+	c.leaseAliveMutex.Lock()
+	defer c.leaseAliveMutex.Unlock()
+	c.leaseLost = false
+	c.lastKeepAliveTime = time.Now()
+}
+
+// checkLeaseAlive fails fast with ErrLeaseLost if the KeepAlive stream
+// has been lost since the last successful heartbeat, so PutUnderLease
+// doesn't wait out a write that's doomed to be orphaned by a lease
+// revoke.
+func (c *cluster) checkLeaseAlive() error {
+	c.leaseAliveMutex.RLock()
+	defer c.leaseAliveMutex.RUnlock()
+	if c.leaseLost {
+		return &ErrLeaseLost{}
+	}
+	return nil
+}
+
+// LeaseStatus reports whether the cluster's lease KeepAlive stream is
+// currently alive.
+func (c *cluster) LeaseStatus() LeaseStatus {
+	c.leaseAliveMutex.RLock()
+	defer c.leaseAliveMutex.RUnlock()
+
+	status := LeaseStatus{Alive: !c.leaseLost}
+	if !c.lastKeepAliveTime.IsZero() {
+		status.LastKeepAliveTime = c.lastKeepAliveTime.Format(time.RFC3339)
+	}
+	return status
+}
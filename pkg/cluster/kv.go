@@ -0,0 +1,68 @@
+package cluster
+
+import (
+	"fmt"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+// Get retrieves the value of key, returning a nil pointer (not an
+// error) if it doesn't exist.
+func (c *cluster) Get(key string) (*string, error) {
+	client, err := c.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Get(c.requestContext(), key)
+	if err != nil {
+		return nil, fmt.Errorf("get %s failed: %v", key, err)
+	}
+
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+
+	value := string(resp.Kvs[0].Value)
+	return &value, nil
+}
+
+// Put writes key/value with no lease attached.
+func (c *cluster) Put(key, value string) error {
+	client, err := c.getClient()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Put(c.requestContext(), key, value)
+	if err != nil {
+		return fmt.Errorf("put %s failed: %v", key, err)
+	}
+
+	return nil
+}
+
+// PutUnderLease writes key/value under the cluster's own lease, so it's
+// cleaned up automatically if this member goes away.
+func (c *cluster) PutUnderLease(key, value string) error {
+	if err := c.checkLeaseAlive(); err != nil {
+		return err
+	}
+
+	lease, err := c.getLease()
+	if err != nil {
+		return err
+	}
+
+	client, err := c.getClient()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Put(c.requestContext(), key, value, clientv3.WithLease(lease))
+	if err != nil {
+		return fmt.Errorf("put %s under lease failed: %v", key, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,195 @@
+package cluster
+
+import (
+	"context"
+
+	"github.com/megaease/easegateway/pkg/logger"
+
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+)
+
+type (
+	// WatchEvent is a single change observed by Watch/WatchPrefix.
+	WatchEvent struct {
+		// Type is either "PUT" or "DELETE".
+		Type string
+
+		Key   string
+		Value string
+
+		// PrevValue is the value before this change, empty if there
+		// wasn't one.
+		PrevValue string
+
+		// Rev is the mod revision this change happened at.
+		Rev int64
+	}
+)
+
+// watchChanBuffer is the buffer size of channels returned by
+// Watch/WatchPrefix, large enough to absorb a burst without blocking the
+// watch loop against a slow consumer for long.
+const watchChanBuffer = 64
+
+// getWatcher lazily creates the single clientv3.Watcher multiplexed
+// across every Watch/WatchPrefix call, mirroring getClient's DCL.
+func (c *cluster) getWatcher() (clientv3.Watcher, error) {
+	c.watcherMutex.RLock()
+	if c.watcher != nil {
+		watcher := c.watcher
+		c.watcherMutex.RUnlock()
+		return watcher, nil
+	}
+	c.watcherMutex.RUnlock()
+
+	c.watcherMutex.Lock()
+	defer c.watcherMutex.Unlock()
+
+	// DCL
+	if c.watcher != nil {
+		return c.watcher, nil
+	}
+
+	client, err := c.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	c.watcher = clientv3.NewWatcher(client)
+
+	return c.watcher, nil
+}
+
+func (c *cluster) closeWatcher() {
+	c.watcherMutex.Lock()
+	defer c.watcherMutex.Unlock()
+
+	if c.watcher == nil {
+		return
+	}
+
+	if err := c.watcher.Close(); err != nil {
+		logger.Errorf("close watcher failed: %v", err)
+	}
+	c.watcher = nil
+}
+
+// Watch watches the given key.
+func (c *cluster) Watch(ctx context.Context, key string) (<-chan WatchEvent, error) {
+	return c.watch(ctx, key, false)
+}
+
+// WatchPrefix watches every key under the given prefix.
+func (c *cluster) WatchPrefix(ctx context.Context, prefix string) (<-chan WatchEvent, error) {
+	return c.watch(ctx, prefix, true)
+}
+
+func (c *cluster) watch(ctx context.Context, key string, prefix bool) (<-chan WatchEvent, error) {
+	watcher, err := c.getWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []clientv3.OpOption{clientv3.WithCreatedNotify(), clientv3.WithPrevKV()}
+	if prefix {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	c.addWatchCancel(cancel)
+
+	out := make(chan WatchEvent, watchChanBuffer)
+
+	go c.watchLoop(ctx, watcher, key, opts, out)
+
+	return out, nil
+}
+
+// watchLoop drives a single Watch/WatchPrefix channel, auto-resuming
+// from the last observed revision whenever the underlying watch stream
+// is torn down by a reconnect.
+func (c *cluster) watchLoop(ctx context.Context, watcher clientv3.Watcher, key string, opts []clientv3.OpOption, out chan<- WatchEvent) {
+	defer close(out)
+
+	var rev int64
+	for {
+		watchOpts := opts
+		if rev > 0 {
+			watchOpts = append(append([]clientv3.OpOption{}, opts...), clientv3.WithRev(rev+1))
+		}
+
+		watchChan := watcher.Watch(ctx, key, watchOpts...)
+		for resp := range watchChan {
+			if resp.Canceled {
+				logger.Infof("watch %s canceled: %v", key, resp.Err())
+				return
+			}
+			if err := resp.Err(); err != nil {
+				logger.Errorf("watch %s failed: %v", key, err)
+				break
+			}
+
+			rev = resp.Header.Revision
+
+			for _, event := range resp.Events {
+				select {
+				case out <- toWatchEvent(event):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.done:
+			return
+		default:
+			logger.Infof("watch %s disconnected, resuming from revision %d", key, rev+1)
+		}
+	}
+}
+
+// toWatchEvent converts a raw mvccpb.Event into a WatchEvent, split out
+// of watchLoop so the translation can be unit tested without a live
+// watch stream.
+func toWatchEvent(event *clientv3.Event) WatchEvent {
+	watchEvent := WatchEvent{
+		Key:   string(event.Kv.Key),
+		Value: string(event.Kv.Value),
+		Rev:   event.Kv.ModRevision,
+	}
+
+	if event.Type == mvccpb.DELETE {
+		watchEvent.Type = "DELETE"
+	} else {
+		watchEvent.Type = "PUT"
+	}
+
+	if event.PrevKv != nil {
+		watchEvent.PrevValue = string(event.PrevKv.Value)
+	}
+
+	return watchEvent
+}
+
+func (c *cluster) addWatchCancel(cancel context.CancelFunc) {
+	c.watchMutex.Lock()
+	defer c.watchMutex.Unlock()
+	c.watchCancels = append(c.watchCancels, cancel)
+}
+
+func (c *cluster) closeWatches() {
+	c.watchMutex.Lock()
+	cancels := c.watchCancels
+	c.watchCancels = nil
+	c.watchMutex.Unlock()
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+
+	c.closeWatcher()
+}
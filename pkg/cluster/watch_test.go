@@ -0,0 +1,52 @@
+package cluster
+
+import (
+	"testing"
+
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+)
+
+func TestToWatchEvent(t *testing.T) {
+	tests := []struct {
+		name  string
+		event *clientv3.Event
+		want  WatchEvent
+	}{
+		{
+			name: "put without prev",
+			event: &clientv3.Event{
+				Type: mvccpb.PUT,
+				Kv:   &mvccpb.KeyValue{Key: []byte("/a"), Value: []byte("v1"), ModRevision: 3},
+			},
+			want: WatchEvent{Type: "PUT", Key: "/a", Value: "v1", Rev: 3},
+		},
+		{
+			name: "put with prev",
+			event: &clientv3.Event{
+				Type:   mvccpb.PUT,
+				Kv:     &mvccpb.KeyValue{Key: []byte("/a"), Value: []byte("v2"), ModRevision: 4},
+				PrevKv: &mvccpb.KeyValue{Value: []byte("v1")},
+			},
+			want: WatchEvent{Type: "PUT", Key: "/a", Value: "v2", PrevValue: "v1", Rev: 4},
+		},
+		{
+			name: "delete",
+			event: &clientv3.Event{
+				Type:   mvccpb.DELETE,
+				Kv:     &mvccpb.KeyValue{Key: []byte("/a"), ModRevision: 5},
+				PrevKv: &mvccpb.KeyValue{Value: []byte("v2")},
+			},
+			want: WatchEvent{Type: "DELETE", Key: "/a", PrevValue: "v2", Rev: 5},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := toWatchEvent(test.event)
+			if got != test.want {
+				t.Errorf("toWatchEvent() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}